@@ -0,0 +1,88 @@
+package wssrv
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenIssuer mints and verifies the access tokens requestChannelId
+// hands out for a channel. A token is only valid for the channel and
+// reservation tag it was issued for, and expires after ttl, so a
+// shared link stops working on its own.
+type tokenIssuer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// newTokenIssuer creates a tokenIssuer that signs with secret and
+// issues tokens valid for ttl.
+func newTokenIssuer(secret []byte, ttl time.Duration) *tokenIssuer {
+	return &tokenIssuer{secret: secret, ttl: ttl}
+}
+
+// issue returns a new access token for channel, bound to tag (see
+// newReservationTag), valid for t.ttl.
+func (t *tokenIssuer) issue(channel, tag string) string {
+	expiry := time.Now().Add(t.ttl).Unix()
+	payload := fmt.Sprintf("%s.%s.%d", channel, tag, expiry)
+	sig := t.sign(payload)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// verify reports whether token is a currently-valid access token for
+// channel, bound to tag. Binding to tag means a token stops verifying
+// as soon as its reservation is released and channel is handed to
+// someone else with a new tag, even if the token itself hasn't
+// expired yet; see store.ChannelStore.Reserve.
+func (t *tokenIssuer) verify(channel, tag, token string) bool {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	if !hmac.Equal(sig, t.sign(string(payload))) {
+		return false
+	}
+
+	fields := strings.SplitN(string(payload), ".", 3)
+	if len(fields) != 3 || fields[0] != channel || fields[1] != tag {
+		return false
+	}
+	expiry, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return false
+	}
+	return time.Now().Unix() <= expiry
+}
+
+// sign computes the HMAC-SHA256 of payload under t.secret.
+func (t *tokenIssuer) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// newReservationTag generates a random tag to bind a token to the
+// specific store reservation it was issued for; see
+// store.ChannelStore.Reserve and tokenIssuer.issue.
+func newReservationTag() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
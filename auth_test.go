@@ -0,0 +1,66 @@
+package wssrv
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenIssuerVerify(t *testing.T) {
+	issuer := newTokenIssuer([]byte("secret"), time.Hour)
+	token := issuer.issue("room1", "tag1")
+
+	cases := []struct {
+		name    string
+		channel string
+		tag     string
+		token   string
+		want    bool
+	}{
+		{"valid", "room1", "tag1", token, true},
+		{"wrong channel", "room2", "tag1", token, false},
+		{"wrong tag", "room1", "tag2", token, false},
+		{"tampered", "room1", "tag1", token + "x", false},
+		{"empty token", "room1", "tag1", "", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := issuer.verify(tc.channel, tc.tag, tc.token); got != tc.want {
+				t.Errorf("verify(%q, %q, token) = %v, want %v", tc.channel, tc.tag, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTokenIssuerVerifyExpired(t *testing.T) {
+	issuer := newTokenIssuer([]byte("secret"), -time.Minute)
+	token := issuer.issue("room1", "tag1")
+
+	if issuer.verify("room1", "tag1", token) {
+		t.Fatal("want expired token to fail verification")
+	}
+}
+
+func TestTokenIssuerVerifyWrongSecret(t *testing.T) {
+	issuer := newTokenIssuer([]byte("secret"), time.Hour)
+	token := issuer.issue("room1", "tag1")
+
+	other := newTokenIssuer([]byte("different"), time.Hour)
+	if other.verify("room1", "tag1", token) {
+		t.Fatal("want token signed with a different secret to fail verification")
+	}
+}
+
+func TestNewReservationTagUnique(t *testing.T) {
+	a, err := newReservationTag()
+	if err != nil {
+		t.Fatalf("newReservationTag: %v", err)
+	}
+	b, err := newReservationTag()
+	if err != nil {
+		t.Fatalf("newReservationTag: %v", err)
+	}
+	if a == b {
+		t.Fatal("want distinct tags from successive calls")
+	}
+}
@@ -0,0 +1,140 @@
+// Command wssrv runs the peer-to-peer signaling server.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/holkerveen/wsserver"
+	"github.com/holkerveen/wsserver/store"
+)
+
+func main() {
+	bindAddress := flag.String("bind", envOr("WSSRV_BIND", ""), "address to bind to")
+	port := flag.Int("port", envIntOr("WSSRV_PORT", 8000), "port to listen on")
+	tlsCertFile := flag.String("tls-cert", envOr("WSSRV_TLS_CERT", ""), "TLS certificate file; enables TLS together with -tls-key")
+	tlsKeyFile := flag.String("tls-key", envOr("WSSRV_TLS_KEY", ""), "TLS key file; enables TLS together with -tls-cert")
+	allowedOrigins := flag.String("allowed-origins", envOr("WSSRV_ALLOWED_ORIGINS", ""), "comma-separated list of allowed Origin header values; empty allows any origin")
+	maxChannels := flag.Int("max-channels", envIntOr("WSSRV_MAX_CHANNELS", 0), "maximum number of channels this instance will serve at once; 0 for unlimited")
+	channelIDLength := flag.Int("channel-id-length", envIntOr("WSSRV_CHANNEL_ID_LENGTH", 12), "number of characters in a generated channel id")
+	roomCapacity := flag.Int("room-capacity", envIntOr("WSSRV_ROOM_CAPACITY", 0), "default and maximum member limit for a room; a request can only lower it; 0 falls back to hub.DefaultCapacity")
+	maxMessageSize := flag.Int64("max-message-size", int64(envIntOr("WSSRV_MAX_MESSAGE_SIZE", 0)), "maximum size, in bytes, of a single incoming message; 0 for no limit")
+	readTimeout := flag.Duration("read-timeout", 60*time.Second, "how long a connection may go without a pong before it is dropped")
+	writeTimeout := flag.Duration("write-timeout", 10*time.Second, "how long a single write to a connection may take")
+	logLevel := flag.String("log-level", envOr("WSSRV_LOG_LEVEL", "info"), "log level: info or debug")
+	backend := flag.String("backend", envOr("WSSRV_BACKEND", "memory"), "channel store backend: memory or redis")
+	redisAddr := flag.String("redis-addr", envOr("WSSRV_REDIS_ADDR", "localhost:6379"), "redis address, used when -backend=redis")
+	tokenSecret := flag.String("token-secret", envOr("WSSRV_TOKEN_SECRET", ""), "secret used to sign channel access tokens; if left empty, a random one is generated and a warning is logged")
+	tokenTTL := flag.Duration("token-ttl", 24*time.Hour, "how long an issued channel access token remains valid")
+	iceConfigFile := flag.String("ice-config", envOr("WSSRV_ICE_CONFIG", ""), "path to a JSON file describing the STUN/TURN servers to advertise to clients")
+	stunURLs := flag.String("stun-urls", envOr("WSSRV_STUN_URLS", ""), "comma-separated list of STUN server URLs to advertise; ignored if -ice-config is set")
+	turnURLs := flag.String("turn-urls", envOr("WSSRV_TURN_URLS", ""), "comma-separated list of TURN server URLs to advertise; ignored if -ice-config is set")
+	turnSecret := flag.String("turn-secret", envOr("WSSRV_TURN_SECRET", ""), "shared secret used to mint ephemeral TURN credentials; ignored if -ice-config is set")
+	flag.Parse()
+
+	cs, err := newChannelStore(*backend, *redisAddr)
+	if err != nil {
+		log.Fatalf("could not set up channel store: %v", err)
+	}
+
+	ice, err := loadIceConfig(*iceConfigFile, *stunURLs, *turnURLs, *turnSecret)
+	if err != nil {
+		log.Fatalf("could not load ice config: %v", err)
+	}
+
+	cfg := wssrv.Config{
+		BindAddress:     *bindAddress,
+		Port:            *port,
+		TLSCertFile:     *tlsCertFile,
+		TLSKeyFile:      *tlsKeyFile,
+		AllowedOrigins:  splitAndTrim(*allowedOrigins),
+		MaxChannels:     *maxChannels,
+		ChannelIDLength: *channelIDLength,
+		RoomCapacity:    *roomCapacity,
+		MaxMessageSize:  *maxMessageSize,
+		ReadTimeout:     *readTimeout,
+		WriteTimeout:    *writeTimeout,
+		LogLevel:        *logLevel,
+		Store:           cs,
+		TokenSecret:     []byte(*tokenSecret),
+		TokenTTL:        *tokenTTL,
+		Ice:             ice,
+	}
+
+	fmt.Printf("wssrv start (backend=%v, addr=%v)\n", *backend, cfg.Addr())
+
+	server := wssrv.NewServer(cfg)
+	if err := server.Run(context.Background()); err != nil {
+		log.Fatalf("server error: %v", err)
+	}
+}
+
+// loadIceConfig builds the ICE config to advertise to clients. If
+// configFile is set, it is read as JSON and the other arguments are
+// ignored; otherwise the config is assembled from them.
+func loadIceConfig(configFile, stunURLs, turnURLs, turnSecret string) (wssrv.IceConfig, error) {
+	if configFile != "" {
+		return wssrv.LoadIceConfigFile(configFile)
+	}
+	return wssrv.IceConfig{
+		StunURLs:   splitAndTrim(stunURLs),
+		TurnURLs:   splitAndTrim(turnURLs),
+		TurnSecret: turnSecret,
+	}, nil
+}
+
+// newChannelStore builds the store.ChannelStore selected by backend.
+func newChannelStore(backend, redisAddr string) (store.ChannelStore, error) {
+	switch backend {
+	case "memory":
+		return store.NewMemory(), nil
+	case "redis":
+		return store.NewRedis(redis.NewClient(&redis.Options{Addr: redisAddr})), nil
+	default:
+		return nil, fmt.Errorf("unknown channel store backend %q", backend)
+	}
+}
+
+// envOr returns the environment variable key, or fallback if it is
+// unset or empty.
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// envIntOr returns the environment variable key parsed as an int, or
+// fallback if it is unset, empty, or not a valid int.
+func envIntOr(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	var n int
+	if _, err := fmt.Sscanf(v, "%d", &n); err != nil {
+		return fallback
+	}
+	return n
+}
+
+// splitAndTrim splits a comma-separated list and drops empty
+// entries, returning nil for an empty input.
+func splitAndTrim(list string) []string {
+	if strings.TrimSpace(list) == "" {
+		return nil
+	}
+	var out []string
+	for _, part := range strings.Split(list, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,150 @@
+package hub
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Config tunes the connection-level behavior every Client in a Hub
+// shares. Zero-value fields fall back to the defaults returned by
+// DefaultConfig.
+type Config struct {
+	// WriteWait is the time allowed to write a message to a peer.
+	WriteWait time.Duration
+
+	// PongWait is the time allowed to read the next pong message
+	// from a peer before it is considered dead.
+	PongWait time.Duration
+
+	// PingPeriod is how often a ping is sent to each peer. Should be
+	// comfortably less than PongWait.
+	PingPeriod time.Duration
+
+	// MaxPendingMessages bounds how many outbound messages a client
+	// may have queued before it is considered too slow and dropped.
+	MaxPendingMessages int
+
+	// MaxMessageSize caps the size, in bytes, of a single incoming
+	// message. Zero means no limit.
+	MaxMessageSize int64
+
+	// DefaultCapacity is the member limit given to a room created
+	// without an explicit per-room capacity. Zero falls back to
+	// DefaultCapacity (the package constant).
+	DefaultCapacity int
+}
+
+// defaultCapacity returns c.DefaultCapacity, or the package-level
+// DefaultCapacity constant if it is unset.
+func (c Config) defaultCapacity() int {
+	if c.DefaultCapacity <= 0 {
+		return DefaultCapacity
+	}
+	return c.DefaultCapacity
+}
+
+// DefaultConfig returns the server's original connection settings.
+func DefaultConfig() Config {
+	pongWait := 60 * time.Second
+	return Config{
+		WriteWait:          10 * time.Second,
+		PongWait:           pongWait,
+		PingPeriod:         (pongWait * 9) / 10,
+		MaxPendingMessages: 16,
+	}
+}
+
+// Client wraps a websocket connection with a buffered outbound
+// channel, so that a slow peer can never block the hub or any other
+// client.
+type Client struct {
+	hub  *Hub
+	conn *websocket.Conn
+	send chan []byte
+
+	channel string
+	id      string
+	role    string
+	name    string
+}
+
+// NewClient wraps conn for use with hub. The caller must still call
+// hub.Register before starting the pumps.
+func NewClient(hub *Hub, conn *websocket.Conn) *Client {
+	return &Client{
+		hub:  hub,
+		conn: conn,
+		send: make(chan []byte, hub.cfg.MaxPendingMessages),
+	}
+}
+
+// Send enqueues data for delivery to this client. If the client's
+// buffer is full the message is dropped; Send itself does not
+// unregister the client, but the hub drops it as too slow the next
+// time one of its own deliveries finds the buffer full.
+func (c *Client) Send(data []byte) {
+	select {
+	case c.send <- data:
+	default:
+	}
+}
+
+// ReadPump reads messages off the connection and passes each one to
+// handle, resetting the read deadline on every pong. It blocks until
+// the connection errors or closes, then unregisters the client.
+// ReadPump must be called from the connection's own goroutine.
+func (c *Client) ReadPump(handle func(data []byte)) {
+	defer func() {
+		c.hub.Unregister(c)
+		c.conn.Close()
+	}()
+
+	if c.hub.cfg.MaxMessageSize > 0 {
+		c.conn.SetReadLimit(c.hub.cfg.MaxMessageSize)
+	}
+	c.conn.SetReadDeadline(time.Now().Add(c.hub.cfg.PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(c.hub.cfg.PongWait))
+		return nil
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		handle(data)
+	}
+}
+
+// WritePump delivers queued messages to the connection and sends
+// periodic pings, enforcing the configured write deadline on every
+// write. It returns once send is closed or a write fails, and must
+// be run in its own goroutine.
+func (c *Client) WritePump() {
+	ticker := time.NewTicker(c.hub.cfg.PingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.cfg.WriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.cfg.WriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
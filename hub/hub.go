@@ -0,0 +1,518 @@
+// Package hub implements a concurrency-safe broadcast hub for wssrv
+// signaling channels. All shared state -- registered clients, rooms
+// and their membership -- is owned by the goroutine running Run, so
+// callers never need to take a lock. Anything that must be visible
+// to other wssrv instances is delegated to a store.ChannelStore.
+package hub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/holkerveen/wsserver/store"
+)
+
+// DefaultCapacity is the number of peers allowed in a room that was
+// created without an explicit capacity.
+const DefaultCapacity = 8
+
+// ErrChannelFull is returned by Join when the target channel has
+// already reached its capacity.
+var ErrChannelFull = errors.New("channel is full")
+
+// RolePeer and RoleMaster are the two roles a client can hold in a
+// room. The master is the client that caused the room to be
+// created; every other joiner is a peer.
+const (
+	RoleMaster = "master"
+	RolePeer   = "peer"
+)
+
+// PeerInfo describes one member of a room, for callers that need to
+// render or announce membership.
+type PeerInfo struct {
+	ID   string
+	Role string
+	Name string
+}
+
+// room tracks the clients this instance is serving for a channel. A
+// client connected to a different wssrv instance shares the channel
+// through the store instead of appearing in members here.
+type room struct {
+	members     map[string]*Client // keyed by Client.id
+	capacity    int
+	unsubscribe func() // non-nil once relay has subscribed this channel with the store
+}
+
+// joinRequest asks the hub to add a client to a channel.
+type joinRequest struct {
+	channel  string
+	client   *Client
+	role     string
+	name     string
+	capacity int
+	result   chan joinResult
+}
+
+type joinResult struct {
+	peers []PeerInfo
+	err   error
+}
+
+// leaveRequest asks the hub to remove a client from its current
+// channel without disconnecting it.
+type leaveRequest struct {
+	client *Client
+	result chan leaveResult
+}
+
+type leaveResult struct {
+	channel string
+	peers   []PeerInfo
+}
+
+// peersRequest asks the hub for the current membership of a channel.
+type peersRequest struct {
+	channel string
+	result  chan []PeerInfo
+}
+
+// isMemberRequest asks the hub whether a client is a current local
+// member of a channel.
+type isMemberRequest struct {
+	channel string
+	client  *Client
+	result  chan bool
+}
+
+// sendToRequest asks the hub to unicast data to one local member of
+// a channel by peer ID.
+type sendToRequest struct {
+	channel string
+	target  string
+	data    []byte
+	result  chan bool
+}
+
+// broadcastRequest asks the hub to fan data out to every other
+// client sharing a channel with the sender, on this instance or any
+// other sharing the same store.
+type broadcastRequest struct {
+	channel string
+	from    *Client
+	data    []byte
+}
+
+// notifyAll is a request to deliver data to every local member of a
+// channel, including the one that triggered it.
+type notifyAll struct {
+	channel string
+	data    []byte
+}
+
+// countRequest asks the hub how many channels it currently serves
+// locally.
+type countRequest struct {
+	result chan int
+}
+
+// Hub owns the set of connected clients and their room membership.
+// It is driven entirely through its exported methods; Run must be
+// started in its own goroutine before any of them are used.
+type Hub struct {
+	register   chan *Client
+	unregister chan *Client
+	join       chan joinRequest
+	leaveCh    chan leaveRequest
+	broadcast  chan broadcastRequest
+	notify     chan notifyAll
+	peersCh    chan peersRequest
+	isMemberCh chan isMemberRequest
+	sendToCh   chan sendToRequest
+	countCh    chan countRequest
+	remote     chan store.Envelope
+
+	cfg     Config
+	store   store.ChannelStore
+	clients map[*Client]bool
+	rooms   map[string]*room
+}
+
+// New creates an unstarted Hub backed by cs for anything that must
+// be shared with other wssrv instances: channel reservation,
+// membership bookkeeping, and signaling message fan-out. Zero-value
+// fields of cfg fall back to DefaultConfig.
+func New(cs store.ChannelStore, cfg Config) *Hub {
+	def := DefaultConfig()
+	if cfg.WriteWait == 0 {
+		cfg.WriteWait = def.WriteWait
+	}
+	if cfg.PongWait == 0 {
+		cfg.PongWait = def.PongWait
+	}
+	if cfg.PingPeriod == 0 {
+		cfg.PingPeriod = def.PingPeriod
+	}
+	if cfg.MaxPendingMessages == 0 {
+		cfg.MaxPendingMessages = def.MaxPendingMessages
+	}
+
+	return &Hub{
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		join:       make(chan joinRequest),
+		leaveCh:    make(chan leaveRequest),
+		broadcast:  make(chan broadcastRequest),
+		notify:     make(chan notifyAll),
+		peersCh:    make(chan peersRequest),
+		isMemberCh: make(chan isMemberRequest),
+		sendToCh:   make(chan sendToRequest),
+		countCh:    make(chan countRequest),
+		remote:     make(chan store.Envelope),
+		cfg:        cfg,
+		store:      cs,
+		clients:    make(map[*Client]bool),
+		rooms:      make(map[string]*room),
+	}
+}
+
+// Run processes register, join, leave, broadcast and query requests.
+// It has no way to be stopped, never returns, and is meant to run for
+// the lifetime of the process.
+func (h *Hub) Run() {
+	for {
+		select {
+		case c := <-h.register:
+			h.clients[c] = true
+		case c := <-h.unregister:
+			h.drop(c)
+		case req := <-h.join:
+			peers, err := h.doJoin(req.channel, req.client, req.role, req.name, req.capacity)
+			req.result <- joinResult{peers, err}
+		case req := <-h.leaveCh:
+			channel := req.client.channel
+			h.leave(req.client)
+			req.result <- leaveResult{channel, h.peers(channel)}
+		case req := <-h.peersCh:
+			req.result <- h.peers(req.channel)
+		case req := <-h.isMemberCh:
+			req.result <- h.roomMembers(req.channel)[req.client.id] == req.client
+		case req := <-h.sendToCh:
+			req.result <- h.sendTo(req.channel, req.target, req.data)
+		case req := <-h.countCh:
+			req.result <- len(h.rooms)
+		case n := <-h.notify:
+			h.deliverAll(n.channel, n.data)
+		case req := <-h.broadcast:
+			go h.publish(req.channel, req.from.id, req.data)
+		case env := <-h.remote:
+			for id, c := range h.roomMembers(env.Channel) {
+				if id == env.SenderID {
+					continue
+				}
+				h.deliver(id, c, env.Data)
+			}
+		}
+	}
+}
+
+// Register adds a freshly connected client to the hub.
+func (h *Hub) Register(c *Client) { h.register <- c }
+
+// Unregister removes a client and closes its outbound channel.
+func (h *Hub) Unregister(c *Client) { h.unregister <- c }
+
+// Reserve claims id in the hub's channel store, so ids stay unique
+// across every wssrv instance sharing it. tag and ttl are passed
+// straight through to the store; see store.ChannelStore.Reserve.
+func (h *Hub) Reserve(id, tag string, ttl time.Duration) error { return h.store.Reserve(id, tag, ttl) }
+
+// Tag returns the tag id's current reservation was created with, and
+// whether id has a live reservation at all; see
+// store.ChannelStore.Tag.
+func (h *Hub) Tag(id string) (string, bool, error) { return h.store.Tag(id) }
+
+// Join adds c to channel with the given role and display name,
+// creating the room if it doesn't exist yet. capacity sets the new
+// room's member limit, up to cfg.DefaultCapacity; zero, a negative
+// value, or a value above cfg.DefaultCapacity all fall back to it, so
+// a caller can only ever shrink a room's capacity, never raise it
+// past the configured ceiling. capacity is ignored if the room
+// already exists. An empty role
+// resolves to RoleMaster for the client that creates the room and
+// RolePeer for everyone after. It returns the room's local membership
+// after the join, or ErrChannelFull if the room was already at its
+// capacity, counting members on every instance sharing the store.
+func (h *Hub) Join(channel string, c *Client, role, name string, capacity int) ([]PeerInfo, error) {
+	result := make(chan joinResult, 1)
+	h.join <- joinRequest{channel, c, role, name, capacity, result}
+	r := <-result
+	return r.peers, r.err
+}
+
+// Leave removes c from its current channel, if any, and returns that
+// channel's ID and its remaining local membership.
+func (h *Hub) Leave(c *Client) (channel string, peers []PeerInfo) {
+	result := make(chan leaveResult, 1)
+	h.leaveCh <- leaveRequest{c, result}
+	r := <-result
+	return r.channel, r.peers
+}
+
+// Peers returns the current local membership of channel.
+//
+// Like SendTo, Peers only consults this instance's local rooms: with
+// a store shared across instances, a peer connected to a different
+// one is not included, so a hubInfo/listPeers response can
+// under-report a channel's true membership in a multi-instance
+// deployment.
+func (h *Hub) Peers(channel string) []PeerInfo {
+	result := make(chan []PeerInfo, 1)
+	h.peersCh <- peersRequest{channel, result}
+	return <-result
+}
+
+// IsMember reports whether c is currently a local member of channel,
+// so callers can gate channel-scoped commands on actual membership
+// rather than trusting a bare channel ID.
+func (h *Hub) IsMember(channel string, c *Client) bool {
+	result := make(chan bool, 1)
+	h.isMemberCh <- isMemberRequest{channel, c, result}
+	return <-result
+}
+
+// ChannelCount returns the number of channels this instance
+// currently serves at least one local client for.
+func (h *Hub) ChannelCount() int {
+	result := make(chan int, 1)
+	h.countCh <- countRequest{result}
+	return <-result
+}
+
+// SendTo delivers data to the member of channel with the given peer
+// ID, if that peer is connected to this instance. It reports whether
+// the peer was found locally.
+//
+// Unlike Broadcast, SendTo does not consult the store: the minimal
+// ChannelStore interface has no way to address a single peer that
+// might be connected to a different instance, so cross-instance
+// unicast isn't supported yet.
+func (h *Hub) SendTo(channel, target string, data []byte) bool {
+	result := make(chan bool, 1)
+	h.sendToCh <- sendToRequest{channel, target, data, result}
+	return <-result
+}
+
+// NotifyAll delivers data to every local member of channel,
+// including whichever member caused the notification.
+func (h *Hub) NotifyAll(channel string, data []byte) {
+	h.notify <- notifyAll{channel, data}
+}
+
+// Broadcast publishes data to channel via the store, so it reaches
+// every other client sharing from's channel, whether connected to
+// this instance or any other sharing the same store.
+func (h *Hub) Broadcast(channel string, from *Client, data []byte) {
+	h.broadcast <- broadcastRequest{channel, from, data}
+}
+
+// doJoin performs a join synchronously within the Run goroutine.
+func (h *Hub) doJoin(channel string, c *Client, role, name string, capacity int) ([]PeerInfo, error) {
+	h.leave(c)
+
+	r := h.rooms[channel]
+	if r == nil {
+		def := h.cfg.defaultCapacity()
+		if capacity <= 0 || capacity > def {
+			capacity = def
+		}
+		r = &room{members: make(map[string]*Client), capacity: capacity}
+		h.rooms[channel] = r
+		if role == "" {
+			role = RoleMaster
+		}
+	} else if role == "" {
+		role = RolePeer
+	}
+
+	// The capacity check is against the store's global member count,
+	// not just len(r.members), so a room's capacity is enforced across
+	// every instance sharing the store, not per instance. Our own
+	// just-admitted local members may not have reached the store yet
+	// (storeJoin runs off this goroutine), so the local count -- which
+	// is always immediately accurate -- is used as a floor. Apart from
+	// the store Leave that h.leave above makes when c switches rooms,
+	// this is the one store call left synchronous in the join path,
+	// since the admission decision genuinely depends on its result.
+	count, err := h.store.MemberCount(channel)
+	if err != nil {
+		log.Printf("store member count %v failed: %v", channel, err)
+		count = 0
+	}
+	if local := len(r.members); local > count {
+		count = local
+	}
+	if count >= r.capacity {
+		if len(r.members) == 0 {
+			delete(h.rooms, channel)
+		}
+		return nil, ErrChannelFull
+	}
+
+	if c.id == "" {
+		c.id = newPeerID()
+	}
+	c.channel = channel
+	c.role = role
+	c.name = name
+	r.members[c.id] = c
+
+	go h.storeJoin(channel, c.id)
+
+	if r.unsubscribe == nil {
+		r.unsubscribe = h.relay(channel)
+	}
+
+	return h.peers(channel), nil
+}
+
+// storeJoin records a join with the store off the Run goroutine, so
+// a slow store (e.g. Redis under load) can't stall every other
+// client waiting on Run.
+func (h *Hub) storeJoin(channel, clientID string) {
+	if err := h.store.Join(channel, clientID); err != nil {
+		log.Printf("store join %v failed: %v", channel, err)
+	}
+}
+
+// publish issues a store Publish off the Run goroutine. Broadcasts
+// happen on every signaling message, so unlike a join or leave, a
+// blocking round trip here would stall every other client behind the
+// store's latency for the whole time it's in flight.
+func (h *Hub) publish(channel, senderID string, data []byte) {
+	if err := h.store.Publish(channel, senderID, data); err != nil {
+		log.Printf("publish to %v failed: %v", channel, err)
+	}
+}
+
+// relay forwards everything the store delivers for channel into
+// h.remote, so it can be handed to local members from the Run
+// goroutine, and returns the function that tears the subscription
+// down again. It is started once per channel per Hub, the first time
+// a local client joins it; its cancel is invoked by leave once the
+// channel's last local member leaves, so a drain/rejoin cycle never
+// ends up with two relays feeding the same channel. Subscribe itself
+// doesn't do a blocking round trip, so only the per-message
+// forwarding loop below needs its own goroutine.
+func (h *Hub) relay(channel string) func() {
+	envelopes, cancel := h.store.Subscribe(channel)
+	go func() {
+		for env := range envelopes {
+			h.remote <- env
+		}
+	}()
+	return cancel
+}
+
+// leave removes c from its current channel, if any. Must only be
+// called from the Run goroutine. Unlike relay's cancel, the store
+// Leave call is kept synchronous: a room that empties here may be
+// immediately rejoined, and a subsequent doJoin should see this
+// departure already reflected in the store.
+func (h *Hub) leave(c *Client) {
+	r := h.rooms[c.channel]
+	if r == nil {
+		return
+	}
+	delete(r.members, c.id)
+	if len(r.members) == 0 {
+		delete(h.rooms, c.channel)
+		if r.unsubscribe != nil {
+			go r.unsubscribe()
+		}
+	}
+	if err := h.store.Leave(c.channel, c.id); err != nil {
+		log.Printf("store leave %v failed: %v", c.channel, err)
+	}
+	c.channel = ""
+}
+
+// peers lists the local membership of channel. Must only be called
+// from the Run goroutine.
+func (h *Hub) peers(channel string) []PeerInfo {
+	r := h.rooms[channel]
+	if r == nil {
+		return nil
+	}
+	peers := make([]PeerInfo, 0, len(r.members))
+	for _, c := range r.members {
+		peers = append(peers, PeerInfo{ID: c.id, Role: c.role, Name: c.name})
+	}
+	return peers
+}
+
+// roomMembers returns the local member map of channel, or nil. Must
+// only be called from the Run goroutine.
+func (h *Hub) roomMembers(channel string) map[string]*Client {
+	r := h.rooms[channel]
+	if r == nil {
+		return nil
+	}
+	return r.members
+}
+
+// sendTo unicasts data to the local member of channel with the given
+// peer ID. Must only be called from the Run goroutine.
+func (h *Hub) sendTo(channel, target string, data []byte) bool {
+	c, ok := h.roomMembers(channel)[target]
+	if !ok {
+		return false
+	}
+	h.deliver(target, c, data)
+	return true
+}
+
+// deliverAll sends data to every local member of channel. Must only
+// be called from the Run goroutine.
+func (h *Hub) deliverAll(channel string, data []byte) {
+	for id, c := range h.roomMembers(channel) {
+		h.deliver(id, c, data)
+	}
+}
+
+// deliver enqueues data on c's send channel, dropping c if its
+// buffer is full. Must only be called from the Run goroutine.
+func (h *Hub) deliver(id string, c *Client, data []byte) {
+	select {
+	case c.send <- data:
+	default:
+		log.Printf("%v send buffer full, dropping client", c.conn.RemoteAddr())
+		h.drop(c)
+	}
+}
+
+// drop removes c from the hub entirely and closes its send channel
+// so its writePump exits. Must only be called from the Run goroutine.
+func (h *Hub) drop(c *Client) {
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	h.leave(c)
+	delete(h.clients, c)
+	close(c.send)
+}
+
+// newPeerID generates a short random identifier for a client. It is
+// independent of any single Hub instance so IDs stay unique once
+// signaling state is shared across processes.
+func newPeerID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,97 @@
+package hub_test
+
+import (
+	"testing"
+
+	"github.com/holkerveen/wsserver/hub"
+	"github.com/holkerveen/wsserver/store"
+)
+
+func newTestHub(cfg hub.Config) *hub.Hub {
+	h := hub.New(store.NewMemory(), cfg)
+	go h.Run()
+	return h
+}
+
+func TestJoinAssignsMasterThenPeerRoles(t *testing.T) {
+	h := newTestHub(hub.Config{})
+	master := hub.NewClient(h, nil)
+	peer := hub.NewClient(h, nil)
+
+	if _, err := h.Join("room1", master, "", "", 0); err != nil {
+		t.Fatalf("master join: %v", err)
+	}
+	peers, err := h.Join("room1", peer, "", "", 0)
+	if err != nil {
+		t.Fatalf("peer join: %v", err)
+	}
+	if len(peers) != 2 {
+		t.Fatalf("want 2 peers, got %d", len(peers))
+	}
+	roles := map[string]int{}
+	for _, p := range peers {
+		roles[p.Role]++
+	}
+	if roles[hub.RoleMaster] != 1 || roles[hub.RolePeer] != 1 {
+		t.Fatalf("want one master and one peer, got %+v", peers)
+	}
+}
+
+func TestJoinEnforcesCapacity(t *testing.T) {
+	h := newTestHub(hub.Config{})
+	first := hub.NewClient(h, nil)
+	if _, err := h.Join("room2", first, "", "", 1); err != nil {
+		t.Fatalf("first join: %v", err)
+	}
+
+	second := hub.NewClient(h, nil)
+	if _, err := h.Join("room2", second, "", "", 1); err != hub.ErrChannelFull {
+		t.Fatalf("want ErrChannelFull, got %v", err)
+	}
+}
+
+func TestJoinClampsCapacityToConfiguredCeiling(t *testing.T) {
+	h := newTestHub(hub.Config{DefaultCapacity: 1})
+	first := hub.NewClient(h, nil)
+	if _, err := h.Join("room3", first, "", "", 100); err != nil {
+		t.Fatalf("first join: %v", err)
+	}
+
+	second := hub.NewClient(h, nil)
+	if _, err := h.Join("room3", second, "", "", 100); err != hub.ErrChannelFull {
+		t.Fatalf("want ErrChannelFull despite requested capacity 100, got %v", err)
+	}
+}
+
+func TestLeaveFreesCapacity(t *testing.T) {
+	h := newTestHub(hub.Config{})
+	first := hub.NewClient(h, nil)
+	if _, err := h.Join("room4", first, "", "", 1); err != nil {
+		t.Fatalf("first join: %v", err)
+	}
+	h.Leave(first)
+
+	second := hub.NewClient(h, nil)
+	if _, err := h.Join("room4", second, "", "", 1); err != nil {
+		t.Fatalf("second join after leave: %v", err)
+	}
+}
+
+func TestIsMemberReflectsCurrentChannel(t *testing.T) {
+	h := newTestHub(hub.Config{})
+	c := hub.NewClient(h, nil)
+	if _, err := h.Join("room5", c, "", "", 0); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if !h.IsMember("room5", c) {
+		t.Fatal("want member of room5 after joining")
+	}
+	if h.IsMember("other", c) {
+		t.Fatal("want not a member of a channel never joined")
+	}
+
+	h.Leave(c)
+	if h.IsMember("room5", c) {
+		t.Fatal("want not a member of room5 after leaving")
+	}
+}
@@ -0,0 +1,98 @@
+package wssrv
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// IceServer describes one entry of an RTCConfiguration's iceServers
+// list.
+type IceServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// RTCConfiguration is the subset of the WebRTC RTCConfiguration
+// dictionary wssrv knows how to fill in: just the ICE servers.
+type RTCConfiguration struct {
+	IceServers []IceServer `json:"iceServers"`
+}
+
+// IceMessage is pushed to a client right after it connects, so it can
+// set up its RTCPeerConnection without a separate GET /ice request.
+type IceMessage struct {
+	Cmd              string `json:"cmd"`
+	RTCConfiguration `json:"config"`
+}
+
+// IceConfig describes the STUN/TURN servers wssrv advertises to
+// clients, e.g. loaded from a JSON file with LoadIceConfigFile. The
+// zero value advertises no ICE servers at all.
+type IceConfig struct {
+	StunURLs []string `json:"stunUrls"`
+	TurnURLs []string `json:"turnUrls"`
+
+	// TurnSecret, if set, turns on ephemeral coturn-style time-limited
+	// TURN credentials: a username of "<expiry>:<TurnUsername>" and an
+	// HMAC-SHA1 password over that username, keyed with TurnSecret.
+	// Without it, TurnURLs are advertised without credentials.
+	TurnSecret   string        `json:"turnSecret"`
+	TurnUsername string        `json:"turnUsername"`
+	TurnTTL      time.Duration `json:"turnTtl"`
+}
+
+// LoadIceConfigFile reads an IceConfig from a JSON file.
+func LoadIceConfigFile(path string) (IceConfig, error) {
+	var cfg IceConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parsing ice config %v: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// RTCConfiguration builds the ICE server list to hand to a client,
+// minting a fresh ephemeral TURN credential if TurnSecret is set.
+func (c IceConfig) RTCConfiguration() RTCConfiguration {
+	var servers []IceServer
+	if len(c.StunURLs) > 0 {
+		servers = append(servers, IceServer{URLs: c.StunURLs})
+	}
+	if len(c.TurnURLs) > 0 {
+		turn := IceServer{URLs: c.TurnURLs}
+		if c.TurnSecret != "" {
+			turn.Username, turn.Credential = c.turnCredential()
+		}
+		servers = append(servers, turn)
+	}
+	return RTCConfiguration{IceServers: servers}
+}
+
+// turnCredential computes an ephemeral TURN username/password pair,
+// valid for TurnTTL (default one hour), using the scheme coturn's
+// use-auth-secret option expects.
+func (c IceConfig) turnCredential() (username, password string) {
+	ttl := c.TurnTTL
+	if ttl == 0 {
+		ttl = time.Hour
+	}
+	name := c.TurnUsername
+	if name == "" {
+		name = "wssrv"
+	}
+	username = fmt.Sprintf("%d:%s", time.Now().Add(ttl).Unix(), name)
+
+	mac := hmac.New(sha1.New, []byte(c.TurnSecret))
+	mac.Write([]byte(username))
+	password = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return username, password
+}
@@ -0,0 +1,413 @@
+package wssrv
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/holkerveen/wsserver/hub"
+	"github.com/holkerveen/wsserver/store"
+)
+
+// shutdownGrace is how long Run waits for in-flight connections to
+// finish once a shutdown signal arrives.
+const shutdownGrace = 10 * time.Second
+
+// defaultChannelIDLength is used when Config.ChannelIDLength is 0.
+const defaultChannelIDLength = 12
+
+// defaultTokenTTL is used when Config.TokenTTL is 0.
+const defaultTokenTTL = 24 * time.Hour
+
+// Config holds every runtime-tunable setting for a Server.
+type Config struct {
+	// BindAddress and Port are combined into the address the HTTP
+	// server listens on.
+	BindAddress string
+	Port        int
+
+	// TLSCertFile and TLSKeyFile, if both set, make Run serve TLS.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// AllowedOrigins lists the Origin header values the websocket
+	// handshake accepts. An empty list allows any origin; a non-empty
+	// list also rejects a handshake with no Origin header at all,
+	// since a non-browser client could otherwise use the missing
+	// header to bypass the allow-list.
+	AllowedOrigins []string
+
+	// MaxChannels caps how many channels this instance will serve at
+	// once. Zero means unlimited.
+	MaxChannels int
+
+	// ChannelIDLength is the number of characters in a generated
+	// channel id. Zero falls back to defaultChannelIDLength.
+	ChannelIDLength int
+
+	// RoomCapacity is the member limit given to a room created
+	// without an explicit capacity in its join/connect request. Zero
+	// falls back to hub.DefaultCapacity.
+	RoomCapacity int
+
+	// MaxMessageSize caps the size, in bytes, of a single incoming
+	// websocket message. Zero means no limit.
+	MaxMessageSize int64
+
+	// ReadTimeout and WriteTimeout bound how long a connection may go
+	// without a pong and how long a single write may take,
+	// respectively. Zero falls back to hub.DefaultConfig.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// LogLevel is "debug" or "info". "debug" additionally logs empty
+	// messages and every requestChannelId, connectChannel, joinChannel
+	// and leaveChannel received; listPeers, send and sendTo are not
+	// logged.
+	LogLevel string
+
+	// Store backs channel reservation, membership and message
+	// fan-out. It must be set; wssrv does not default it, since the
+	// choice of backend (and how to construct it) is an operational
+	// decision for the caller.
+	Store store.ChannelStore
+
+	// TokenSecret signs the access tokens requestChannelId issues. If
+	// empty, NewServer generates a random secret for the life of the
+	// process and logs a warning, since tokens then stop verifying
+	// across a restart or between instances.
+	TokenSecret []byte
+
+	// TokenTTL is how long an issued access token remains valid. Zero
+	// falls back to defaultTokenTTL.
+	TokenTTL time.Duration
+
+	// Ice describes the STUN/TURN servers advertised to clients via
+	// GET /ice and as the initial websocket message on connect. The
+	// zero value advertises no ICE servers.
+	Ice IceConfig
+}
+
+// Addr returns the address Run listens on.
+func (c Config) Addr() string {
+	return fmt.Sprintf("%s:%d", c.BindAddress, c.Port)
+}
+
+func (c Config) channelIDLength() int {
+	if c.ChannelIDLength <= 0 {
+		return defaultChannelIDLength
+	}
+	return c.ChannelIDLength
+}
+
+func (c Config) originAllowed(origin string) bool {
+	if len(c.AllowedOrigins) == 0 {
+		return true
+	}
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// Server serves the signaling websocket endpoint described by its
+// Config, on top of a hub.Hub it owns.
+type Server struct {
+	cfg      Config
+	hub      *hub.Hub
+	tokens   *tokenIssuer
+	upgrader websocket.Upgrader
+	http     *http.Server
+}
+
+// NewServer creates a Server from cfg. The returned Server's Hub is
+// not yet running; call Run to start serving.
+func NewServer(cfg Config) *Server {
+	if cfg.Store == nil {
+		panic("wssrv: Config.Store must be set")
+	}
+
+	if cfg.TokenTTL == 0 {
+		cfg.TokenTTL = defaultTokenTTL
+	}
+	if len(cfg.TokenSecret) == 0 {
+		log.Printf("warning: no TokenSecret configured, generating a random one; issued tokens won't verify across a restart or another instance")
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+		cfg.TokenSecret = secret
+	}
+
+	hubCfg := hub.Config{
+		WriteWait:       cfg.WriteTimeout,
+		PongWait:        cfg.ReadTimeout,
+		MaxMessageSize:  cfg.MaxMessageSize,
+		DefaultCapacity: cfg.RoomCapacity,
+	}
+	if cfg.ReadTimeout > 0 {
+		hubCfg.PingPeriod = (cfg.ReadTimeout * 9) / 10
+	}
+	h := hub.New(cfg.Store, hubCfg)
+
+	s := &Server{
+		cfg:    cfg,
+		hub:    h,
+		tokens: newTokenIssuer(cfg.TokenSecret, cfg.TokenTTL),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin: func(r *http.Request) bool {
+				return cfg.originAllowed(r.Header.Get("Origin"))
+			},
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", s)
+	mux.HandleFunc("/ice", s.serveIce)
+	s.http = &http.Server{Addr: cfg.Addr(), Handler: mux}
+
+	return s
+}
+
+// Run starts the Hub and the HTTP server, and blocks until ctx is
+// cancelled or SIGINT/SIGTERM is received. It then gives in-flight
+// connections shutdownGrace to finish before returning.
+func (s *Server) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go s.hub.Run()
+
+	serve := make(chan error, 1)
+	go func() {
+		var err error
+		if s.cfg.TLSCertFile != "" && s.cfg.TLSKeyFile != "" {
+			err = s.http.ListenAndServeTLS(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+		} else {
+			err = s.http.ListenAndServe()
+		}
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+		serve <- err
+	}()
+
+	select {
+	case err := <-serve:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+		defer cancel()
+		if err := s.http.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+		return <-serve
+	}
+}
+
+// ServeHTTP upgrades the request to a websocket connection,
+// registers it with the hub, and dispatches every signaling message
+// it sends for the lifetime of the connection. A misbehaving or
+// disconnecting client only ever ends its own ServeHTTP call.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("%v upgrade failed: %v", r.RemoteAddr, err)
+		return
+	}
+	log.Printf("%v connected to server", r.RemoteAddr)
+
+	client := hub.NewClient(s.hub, conn)
+	s.hub.Register(client)
+
+	go client.WritePump()
+	s.sendIce(client)
+	client.ReadPump(func(data []byte) {
+		s.dispatch(client, data)
+	})
+
+	log.Printf("%v disconnected", r.RemoteAddr)
+}
+
+// serveIce answers GET /ice with the configured RTCConfiguration, so
+// clients that would rather not wait for the initial websocket
+// message can fetch ICE servers directly.
+func (s *Server) serveIce(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.cfg.Ice.RTCConfiguration()); err != nil {
+		log.Printf("could not encode ice response: %v", err)
+	}
+}
+
+// sendIce pushes the configured ICE servers to c as its first
+// message, so it can set up an RTCPeerConnection without an extra
+// round trip to GET /ice.
+func (s *Server) sendIce(c *hub.Client) {
+	data, err := json.Marshal(IceMessage{Cmd: "ice", RTCConfiguration: s.cfg.Ice.RTCConfiguration()})
+	if err != nil {
+		log.Printf("could not encode ice message: %v", err)
+		return
+	}
+	c.Send(data)
+}
+
+// debugf logs format/args only when Config.LogLevel is "debug".
+func (s *Server) debugf(format string, args ...interface{}) {
+	if s.cfg.LogLevel == "debug" {
+		log.Printf(format, args...)
+	}
+}
+
+// dispatch decodes a single signaling message and acts on it.
+func (s *Server) dispatch(c *hub.Client, data []byte) {
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		log.Printf("invalid message: %v", err)
+		return
+	}
+
+	switch req.Command {
+	case "":
+		s.debugf("empty message")
+	case "requestChannelId":
+		s.debugf("requestChannelId")
+		if s.cfg.MaxChannels > 0 && s.hub.ChannelCount() >= s.cfg.MaxChannels {
+			sendError(c, "server has reached its channel limit")
+			return
+		}
+		tag, err := newReservationTag()
+		if err != nil {
+			log.Printf("could not generate reservation tag: %v", err)
+			return
+		}
+		channelId, failed := generateChannelId(s.hub, s.cfg.channelIDLength(), tag, s.cfg.TokenTTL)
+		if failed {
+			log.Printf("could not generate channel id")
+			return
+		}
+		reply, err := json.Marshal(RequestChannelIdResponse{
+			ChannelId: channelId,
+			Token:     s.tokens.issue(channelId, tag),
+		})
+		if err != nil {
+			log.Printf("could not encode response: %v", err)
+			return
+		}
+		c.Send(reply)
+	case "connectChannel":
+		s.debugf("connectChannel %v", req.Channel)
+		if !s.verifyToken(req.Channel, req.Token) {
+			sendError(c, "invalid or expired channel token")
+			return
+		}
+		s.join(c, req.Channel, "", "", req.Capacity)
+	case "joinChannel":
+		s.debugf("joinChannel %v as %v (%v)", req.Channel, req.Role, req.Name)
+		if !s.verifyToken(req.Channel, req.Token) {
+			sendError(c, "invalid or expired channel token")
+			return
+		}
+		s.join(c, req.Channel, req.Role, req.Name, req.Capacity)
+	case "leaveChannel":
+		channel, peers := s.hub.Leave(c)
+		s.debugf("leaveChannel %v", channel)
+		announceHubInfo(s.hub, channel, peers)
+	case "listPeers":
+		if !s.hub.IsMember(req.Channel, c) {
+			sendError(c, "not a member of this channel")
+			return
+		}
+		sendPeerList(c, s.hub.Peers(req.Channel))
+	case "send":
+		if !s.hub.IsMember(req.Channel, c) {
+			sendError(c, "not a member of this channel")
+			return
+		}
+		s.hub.Broadcast(req.Channel, c, data)
+	case "sendTo":
+		if !s.hub.IsMember(req.Channel, c) {
+			sendError(c, "not a member of this channel")
+			return
+		}
+		if !s.hub.SendTo(req.Channel, req.Target, data) {
+			sendError(c, "no such peer: "+req.Target)
+		}
+	default:
+		log.Printf("unhandled message: %v", req)
+	}
+}
+
+// verifyToken reports whether token is currently valid for channel:
+// its signature and expiry must check out, and it must have been
+// issued for whichever reservation currently holds channel, so a
+// token from a previous, since-released reservation of the same id
+// can't be used to rejoin a reincarnated channel. It fails closed --
+// rejecting the token -- if the store can't be reached to look up the
+// current reservation's tag.
+func (s *Server) verifyToken(channel, token string) bool {
+	tag, ok, err := s.hub.Tag(channel)
+	if err != nil {
+		log.Printf("store tag lookup %v failed: %v", channel, err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	return s.tokens.verify(channel, tag, token)
+}
+
+// join adds c to channel with the given role, name and (if c is the
+// one creating the room) capacity, and announces the resulting
+// membership to the whole room, or tells c why it couldn't join.
+func (s *Server) join(c *hub.Client, channel, role, name string, capacity int) {
+	peers, err := s.hub.Join(channel, c, role, name, capacity)
+	if err != nil {
+		sendError(c, err.Error())
+		return
+	}
+	announceHubInfo(s.hub, channel, peers)
+}
+
+// announceHubInfo pushes peers to every member of channel.
+func announceHubInfo(h *hub.Hub, channel string, peers []hub.PeerInfo) {
+	event := HubInfoEvent{Cmd: "hubInfo", Channel: channel, Peers: toPeerInfo(peers)}
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("could not encode hubInfo: %v", err)
+		return
+	}
+	h.NotifyAll(channel, data)
+}
+
+// sendPeerList replies to c with the given membership.
+func sendPeerList(c *hub.Client, peers []hub.PeerInfo) {
+	data, err := json.Marshal(ListPeersResponse{Cmd: "listPeers", Peers: toPeerInfo(peers)})
+	if err != nil {
+		log.Printf("could not encode listPeers: %v", err)
+		return
+	}
+	c.Send(data)
+}
+
+// sendError replies to c with a structured error message.
+func sendError(c *hub.Client, message string) {
+	data, err := json.Marshal(ErrorResponse{Cmd: "error", Message: message})
+	if err != nil {
+		log.Printf("could not encode error: %v", err)
+		return
+	}
+	c.Send(data)
+}
@@ -0,0 +1,27 @@
+package wssrv
+
+import "testing"
+
+func TestConfigOriginAllowed(t *testing.T) {
+	cases := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"no allow-list permits any origin", nil, "https://example.com", true},
+		{"no allow-list permits an empty origin", nil, "", true},
+		{"allow-list permits a listed origin", []string{"https://a.test"}, "https://a.test", true},
+		{"allow-list rejects an unlisted origin", []string{"https://a.test"}, "https://evil.test", false},
+		{"allow-list rejects an empty origin", []string{"https://a.test"}, "", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Config{AllowedOrigins: tc.allowed}
+			if got := cfg.originAllowed(tc.origin); got != tc.want {
+				t.Errorf("originAllowed(%q) = %v, want %v", tc.origin, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// memoryReservationTTL mirrors Redis's defaultReservationTTL: a
+// reservation nobody joins expires after this long, so a client that
+// calls requestChannelId and never follows up doesn't leak the id --
+// or the map entries behind it -- forever. A non-positive ttl passed
+// to Reserve falls back to it.
+const memoryReservationTTL = 5 * time.Minute
+
+// memorySweepInterval is how often Memory checks for reservations
+// that outlived their ttl without ever being joined.
+const memorySweepInterval = time.Minute
+
+// reservation tracks when an as-yet-unjoined id was reserved and how
+// long it's allowed to stay that way.
+type reservation struct {
+	at  time.Time
+	ttl time.Duration
+}
+
+// Memory is a process-local ChannelStore. It is the default backend
+// and matches the server's original single-process behavior.
+type Memory struct {
+	mu           sync.Mutex
+	members      map[string]map[string]bool
+	reservations map[string]reservation
+	tags         map[string]string
+	subs         map[string][]chan Envelope
+}
+
+// NewMemory creates an empty Memory store and starts its background
+// sweep of expired, never-joined reservations.
+func NewMemory() *Memory {
+	m := &Memory{
+		members:      make(map[string]map[string]bool),
+		reservations: make(map[string]reservation),
+		tags:         make(map[string]string),
+		subs:         make(map[string][]chan Envelope),
+	}
+	go m.sweepLoop()
+	return m
+}
+
+func (m *Memory) sweepLoop() {
+	ticker := time.NewTicker(memorySweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.sweep()
+	}
+}
+
+// sweep releases a reservation that nobody joined before its ttl
+// elapsed, so requestChannelId followed by silence doesn't hold the
+// id -- or its map entries -- forever.
+func (m *Memory) sweep() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, res := range m.reservations {
+		if len(m.members[id]) == 0 && time.Since(res.at) >= res.ttl {
+			delete(m.members, id)
+			delete(m.reservations, id)
+			delete(m.tags, id)
+		}
+	}
+}
+
+// Reserve implements ChannelStore. A reservation with no members is
+// free to reclaim once ttl (or memoryReservationTTL, for a
+// non-positive ttl) has elapsed since it was made; sweep also clears
+// these out in the background so an id nobody retries for stays
+// reclaimed instead of waiting for the next Reserve call that happens
+// to ask for it again.
+func (m *Memory) Reserve(id, tag string, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = memoryReservationTTL
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if members, ok := m.members[id]; ok {
+		if len(members) > 0 {
+			return ErrTaken
+		}
+		if res, ok := m.reservations[id]; ok && time.Since(res.at) < res.ttl {
+			return ErrTaken
+		}
+	}
+	m.members[id] = make(map[string]bool)
+	m.reservations[id] = reservation{at: time.Now(), ttl: ttl}
+	m.tags[id] = tag
+	return nil
+}
+
+// Tag implements ChannelStore.
+func (m *Memory) Tag(id string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tag, ok := m.tags[id]
+	return tag, ok, nil
+}
+
+// Join implements ChannelStore. Clearing the reservation here means
+// a channel with at least one member never expires out from under
+// it; its tag is kept until Leave releases the channel entirely.
+func (m *Memory) Join(id, clientID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.members[id] == nil {
+		m.members[id] = make(map[string]bool)
+	}
+	m.members[id][clientID] = true
+	delete(m.reservations, id)
+	return nil
+}
+
+// Leave implements ChannelStore.
+func (m *Memory) Leave(id, clientID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := m.members[id]
+	if members == nil {
+		return nil
+	}
+	delete(members, clientID)
+	if len(members) == 0 {
+		delete(m.members, id)
+		delete(m.tags, id)
+	}
+	return nil
+}
+
+// MemberCount implements ChannelStore.
+func (m *Memory) MemberCount(id string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.members[id]), nil
+}
+
+// Publish implements ChannelStore. The lock is held for the whole
+// call, including the sends below, so a concurrent cancel can't
+// close a subscriber channel out from under a send in progress.
+func (m *Memory) Publish(id, senderID string, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	env := Envelope{Channel: id, SenderID: senderID, Data: payload}
+	for _, ch := range m.subs[id] {
+		select {
+		case ch <- env:
+		default:
+		}
+	}
+	return nil
+}
+
+// Subscribe implements ChannelStore. The returned cancel function
+// removes and closes only the specific channel this call created, so
+// it can't be tricked into tearing down a different, newer
+// subscription a later Subscribe(id) call adds for the same id.
+func (m *Memory) Subscribe(id string) (<-chan Envelope, func()) {
+	ch := make(chan Envelope, 16)
+	m.mu.Lock()
+	m.subs[id] = append(m.subs[id], ch)
+	m.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			m.mu.Lock()
+			subs := m.subs[id]
+			for i, c := range subs {
+				if c == ch {
+					if rest := append(subs[:i:i], subs[i+1:]...); len(rest) == 0 {
+						delete(m.subs, id)
+					} else {
+						m.subs[id] = rest
+					}
+					break
+				}
+			}
+			m.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
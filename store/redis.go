@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// keyPrefix namespaces every key and pub/sub topic this store uses
+// in the shared Redis instance.
+const keyPrefix = "wssrv:"
+
+// defaultReservationTTL is used when Reserve is called with a
+// non-positive ttl. requestChannelId reserves an id up front but a
+// client may never follow up with connectChannel, and without an
+// expiry that reservation would leak forever. Join persists the key
+// once a member actually joins, so a channel with members never
+// expires out from under them.
+const defaultReservationTTL = 5 * time.Minute
+
+// Redis is a ChannelStore backed by Redis: SET NX reserves channel
+// ids (storing the reservation's tag as the key's value) and a set
+// tracks membership, while pub/sub fans signaling messages out to
+// every subscribed instance.
+type Redis struct {
+	client *redis.Client
+	ctx    context.Context
+}
+
+// NewRedis creates a Redis-backed ChannelStore using client.
+func NewRedis(client *redis.Client) *Redis {
+	return &Redis{client: client, ctx: context.Background()}
+}
+
+// Reserve implements ChannelStore. ttl should cover at least the
+// lifetime of any token already issued for id, so the reservation
+// can't lapse while that token still verifies; a non-positive ttl
+// falls back to defaultReservationTTL.
+func (r *Redis) Reserve(id, tag string, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = defaultReservationTTL
+	}
+	ok, err := r.client.SetNX(r.ctx, keyPrefix+"chan:"+id, tag, ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrTaken
+	}
+	return nil
+}
+
+// Tag implements ChannelStore.
+func (r *Redis) Tag(id string) (string, bool, error) {
+	tag, err := r.client.Get(r.ctx, keyPrefix+"chan:"+id).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return tag, true, nil
+}
+
+// Join implements ChannelStore. Persisting the reservation key here
+// means a channel that has at least one member never expires out
+// from under it; only Leave, once the last member is gone, removes
+// it again.
+func (r *Redis) Join(id, clientID string) error {
+	if err := r.client.Persist(r.ctx, keyPrefix+"chan:"+id).Err(); err != nil {
+		return err
+	}
+	return r.client.SAdd(r.ctx, keyPrefix+"members:"+id, clientID).Err()
+}
+
+// Leave implements ChannelStore. Once a channel's member set is
+// empty, both the set and its reservation (and tag) are deleted so
+// the id can be handed out again.
+func (r *Redis) Leave(id, clientID string) error {
+	key := keyPrefix + "members:" + id
+	if err := r.client.SRem(r.ctx, key, clientID).Err(); err != nil {
+		return err
+	}
+	n, err := r.client.SCard(r.ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return r.client.Del(r.ctx, keyPrefix+"chan:"+id, key).Err()
+	}
+	return nil
+}
+
+// MemberCount implements ChannelStore.
+func (r *Redis) MemberCount(id string) (int, error) {
+	n, err := r.client.SCard(r.ctx, keyPrefix+"members:"+id).Result()
+	return int(n), err
+}
+
+// wireEnvelope is the JSON form of an Envelope sent over Redis
+// pub/sub; the channel id is already carried by the topic name.
+type wireEnvelope struct {
+	SenderID string `json:"sender"`
+	Data     []byte `json:"data"`
+}
+
+// Publish implements ChannelStore.
+func (r *Redis) Publish(id, senderID string, payload []byte) error {
+	body, err := json.Marshal(wireEnvelope{SenderID: senderID, Data: payload})
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(r.ctx, keyPrefix+"pubsub:"+id, body).Err()
+}
+
+// Subscribe implements ChannelStore. The returned cancel function
+// closes this specific PubSub; unlike keying subscriptions by id in
+// a shared map, it can't be tricked into tearing down a different,
+// newer subscription a later Subscribe(id) call created.
+func (r *Redis) Subscribe(id string) (<-chan Envelope, func()) {
+	sub := r.client.Subscribe(r.ctx, keyPrefix+"pubsub:"+id)
+
+	out := make(chan Envelope, 16)
+	go func() {
+		defer close(out)
+		for msg := range sub.Channel() {
+			var wire wireEnvelope
+			if err := json.Unmarshal([]byte(msg.Payload), &wire); err != nil {
+				continue
+			}
+			out <- Envelope{Channel: id, SenderID: wire.SenderID, Data: wire.Data}
+		}
+	}()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			if err := sub.Close(); err != nil {
+				log.Printf("unsubscribe %v failed: %v", id, err)
+			}
+		})
+	}
+	return out, cancel
+}
@@ -0,0 +1,72 @@
+// Package store abstracts how channel reservation, membership and
+// message fan-out are shared between wssrv instances, so the
+// signaling server can be replicated behind a load balancer.
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrTaken is returned by Reserve when the requested channel id is
+// already in use.
+var ErrTaken = errors.New("channel id already reserved")
+
+// Envelope is a signaling message as delivered by a ChannelStore: a
+// payload published by one client, to be handed to every local
+// client subscribed to the same channel (on this instance or any
+// other sharing the store).
+type Envelope struct {
+	Channel  string
+	SenderID string
+	Data     []byte
+}
+
+// ChannelStore is the interface wssrv uses for all channel state.
+// The in-memory Memory implementation matches the server's original
+// single-process behavior; Redis shares state across any number of
+// wssrv instances via SET NX reservation and pub/sub fan-out.
+type ChannelStore interface {
+	// Reserve claims id for a new channel and tags the reservation
+	// with tag, so a later Tag call can tell it apart from a
+	// different reservation that goes on to reuse the same id. It
+	// returns ErrTaken if id is already reserved. ttl bounds how long
+	// the reservation lives before Join makes it permanent; a caller
+	// should pass at least as long as any token it has already issued
+	// for id, so the reservation can't expire and be handed to
+	// someone else while that token is still valid. A zero or
+	// negative ttl leaves the choice of a sane default to the
+	// implementation.
+	Reserve(id, tag string, ttl time.Duration) error
+
+	// Tag returns the tag id's current reservation was created with,
+	// and whether id has a live reservation at all. A caller uses
+	// this to confirm a token was issued for the reservation
+	// currently holding id, not for one since released and reused.
+	Tag(id string) (tag string, ok bool, err error)
+
+	// Join records clientID as a member of channel id.
+	Join(id, clientID string) error
+
+	// Leave removes clientID from channel id. Once a channel's last
+	// member leaves, its reservation is released so the id can be
+	// generated again.
+	Leave(id, clientID string) error
+
+	// MemberCount reports how many clients are currently members of
+	// id, across every instance sharing this store.
+	MemberCount(id string) (int, error)
+
+	// Publish fans payload out to every Subscribe-r of id, including
+	// those on other wssrv instances.
+	Publish(id, senderID string, payload []byte) error
+
+	// Subscribe returns a channel of Envelopes published to id, for
+	// delivering messages to local clients, and a cancel function
+	// that tears down this specific subscription again. cancel is
+	// safe to call more than once, safe to call concurrently with
+	// anything else on the store, and only ever affects the
+	// subscription it was returned for -- not one a later Subscribe
+	// call for the same id creates.
+	Subscribe(id string) (<-chan Envelope, func())
+}
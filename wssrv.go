@@ -1,132 +1,141 @@
 // Simple server for peer-to-peer signaling
 //
 // This server has been written specifically for use as a signaling
-// channel to set up WebRTC connections. It is set up in a full mesh
-// topology; that is: a message sent to the server by a client is
-// forwarded to all other connected peers in the same server
-//
-// Connect procedure is as follows: A master htmldocument page
+// channel to set up WebRTC connections. A master htmldocument page
 // requests a uniqe channel ID and displays is on the page. It will
-// connect to said channel. Any number of client documents can use
-// the same channel id to connect as well.
+// join said channel as the room's master. Any number of peer
+// documents can then join the same channel id.
+//
+// Once joined, a client can broadcast to every other room member
+// with "send", or unicast to one peer with "sendTo" and the peer ID
+// from the room's membership list. Every time a room's membership
+// changes, the server pushes a "hubInfo" event to all members so
+// browsers can render join/leave UI and target WebRTC offers at
+// specific peers instead of blasting SDP to everyone.
 //
-// After that, signaling can start. Any message sent by any client
-// will be pushed to all other clients.
+// Connections are owned by a hub.Hub, which keeps per-instance
+// bookkeeping and delegates anything that must be shared across
+// instances -- channel reservation, membership and message fan-out
+// -- to a store.ChannelStore. A Server ties a Hub to its Config and
+// serves the websocket endpoint.
 package wssrv
 
 import (
-	"net/http"
-	"golang.org/x/net/websocket"
-	"fmt"
-	"math/rand"
-	"log"
-	"io"
+	"crypto/rand"
+	"math/big"
+	"time"
+
+	"github.com/holkerveen/wsserver/hub"
 )
 
 // Request describes the structure of requests sent by the clients
 type Request struct {
 	Command string `json:"cmd"`
 	Channel string `json:"channel"`
-	Data string `json:"data"`
+	Data    string `json:"data"`
+
+	// Role and Name are used by joinChannel to describe the joining
+	// client. Role is normally left empty and inferred by the hub.
+	Role string `json:"role,omitempty"`
+	Name string `json:"name,omitempty"`
+
+	// Target is the peer ID a sendTo message should be delivered to.
+	Target string `json:"target,omitempty"`
+
+	// Capacity sets the member limit of the room a connectChannel or
+	// joinChannel request creates, up to Config.RoomCapacity -- a
+	// client can shrink a room's capacity but never raise it past
+	// that configured ceiling. It only takes effect for the client
+	// that causes the room to be created and is ignored when joining
+	// an existing one. Zero falls back to Config.RoomCapacity.
+	Capacity int `json:"capacity,omitempty"`
+
+	// Token is the access token issued for Channel by a prior
+	// requestChannelId, required by connectChannel and joinChannel.
+	Token string `json:"token,omitempty"`
 }
 
 // RequestChannelIdResponse is a response type for the channelId
 // request
 type RequestChannelIdResponse struct {
 	ChannelId string `json:"cid"`
+
+	// Token is the access token for ChannelId. It must be presented
+	// by connectChannel/joinChannel, so anyone without it -- even
+	// someone who guesses ChannelId -- cannot join or eavesdrop.
+	Token string `json:"token"`
 }
 
-// Variable containing all channels and associated connections
-var connections = make(map[string][]*websocket.Conn)
+// PeerInfo describes one member of a room, as sent to clients in a
+// hubInfo event or a listPeers response.
+type PeerInfo struct {
+	ID   string `json:"id"`
+	Role string `json:"role"`
+	Name string `json:"name,omitempty"`
+}
 
-// letters define the set of characters used to generate a channel
-// id.
-const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-const generateChannelIdLength = 4
-const generateMaxTries = 20
+// HubInfoEvent is pushed to every member of a channel whenever its
+// membership changes. Like a listPeers response, Peers only reflects
+// this instance's local membership; see hub.Hub.Peers.
+type HubInfoEvent struct {
+	Cmd     string     `json:"cmd"`
+	Channel string     `json:"channel"`
+	Peers   []PeerInfo `json:"peers"`
+}
 
-// generateChannelId attempts to generate a new channel id.
-// It generates a random string of the available charachters, and
-// tries again if the generated channel id has already been used.
-func generateChannelId()(string,bool) {
-	id := make([]byte,generateChannelIdLength)
-	for try:=0; try<generateMaxTries; try++ {
-		for i := range id {
-			id[i] = letters[rand.Intn(len(letters))]
-		}
-		if _,exists:=connections[string(id)]; !exists {
-			return string(id),false
-		}
-	}
-	return "",true
+// ListPeersResponse answers a listPeers request. Peers only reflects
+// this instance's local membership; see hub.Hub.Peers.
+type ListPeersResponse struct {
+	Cmd   string     `json:"cmd"`
+	Peers []PeerInfo `json:"peers"`
 }
 
-// EchoServer contains the main server loop
-func WsHandler(ws *websocket.Conn) {
-	log.Printf("%v connected to server",ws.Request().RemoteAddr)
-	addr := ws.Request().RemoteAddr
+// ErrorResponse is sent back to a client whose request could not be
+// carried out, e.g. because a room was full.
+type ErrorResponse struct {
+	Cmd     string `json:"cmd"`
+	Message string `json:"message"`
+}
 
-	// Cleanup
-	defer func() {
-		// TODO: remove conn from channel
-		if err:=ws.Close(); err != nil {
-			log.Panicf("%v cleanup could not close connecteion: %v",addr,err.Error())
-		}
-	}()
-
-	var data Request
-	for {
-		err := websocket.JSON.Receive(ws,&data)
-		switch {
-		case err == io.EOF:
-				log.Printf("%v disconnected",addr)
-				return
-		default:
-				panic(err.Error())
-		case err == nil:
-		}
+// letters define the set of characters used to generate a channel
+// id. Channel ids are also guarded by an access token, but a large
+// charset and length still keep them expensive to guess or
+// enumerate outright.
+const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+const generateMaxTries = 20
 
-		switch data.Command {
-		case "":
-			fmt.Printf("empty message\n")
-		case "requestChannelId":
-			log.Printf("%v requestChannelId",addr)
-			channelId, err := generateChannelId()
-			if err {
-				log.Panicf("%v could not generate channel id",addr)
-				return
-			}
-			connections[channelId] = []*websocket.Conn{}
-			response := RequestChannelIdResponse{
-				ChannelId:channelId,
-			}
-			websocket.JSON.Send(ws,response)
-		case "connectChannel":
-			log.Printf("%v connectChannel",addr)
-			// TODO: disconnect from previous channel
-			connections[data.Channel] = append(connections[data.Channel],ws)
-		case "send":
-			log.Printf("%v send",addr)
-			/* Iterate all connections */
-			for _,conn := range connections[data.Channel] {
-				if conn != ws {
-					websocket.JSON.Send(conn,data)
-				}
+// generateChannelId attempts to generate a new channel id of the
+// given length. It generates a random string of the available
+// charachters using a cryptographically secure source, and reserves
+// it through h's channel store under tag, trying again if it has
+// already been taken -- whether by this instance or another sharing
+// the same store. tag is the value a later Hub.Tag(id) lookup must
+// match for a token to verify; see tokenIssuer.issue. reservationTTL
+// should cover at least the lifetime of the token that will be issued
+// for the id, so the reservation can't lapse while that token is
+// still valid.
+func generateChannelId(h *hub.Hub, length int, tag string, reservationTTL time.Duration) (string, bool) {
+	id := make([]byte, length)
+	for try := 0; try < generateMaxTries; try++ {
+		for i := range id {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(letters))))
+			if err != nil {
+				return "", true
 			}
-		default:
-			log.Panicf("%v Unhandled message\n%v",addr,data)
+			id[i] = letters[n.Int64()]
+		}
+		if h.Reserve(string(id), tag, reservationTTL) == nil {
+			return string(id), false
 		}
 	}
+	return "", true
 }
 
-
-// main is the program entry point. Execution starts here.
-func main() {
-	fmt.Printf("wssrv start\n")
-
-	http.Handle("/", websocket.Handler(WsHandler))
-	err := http.ListenAndServe(":8000",nil)
-	if err != nil {
-		panic("ListenAndServe: "+err.Error())
+// toPeerInfo converts hub.PeerInfo values to their wire form.
+func toPeerInfo(peers []hub.PeerInfo) []PeerInfo {
+	out := make([]PeerInfo, len(peers))
+	for i, p := range peers {
+		out[i] = PeerInfo{ID: p.ID, Role: p.Role, Name: p.Name}
 	}
+	return out
 }